@@ -0,0 +1,298 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/vaitekunas/log/logrpc"
+)
+
+// Backend receives a copy of every log entry RemoteLog processes, in
+// addition to the local log.Logger, so entries can also be forwarded to an
+// external system (syslog, a chained LogServer, ...).
+type Backend interface {
+
+	// Write delivers entry to the backend
+	Write(entry *logrpc.LogEntry) error
+
+	// Close releases any connection held by the backend
+	Close() error
+}
+
+// BackendType selects which kind of Backend a BackendConfig describes
+type BackendType string
+
+const (
+	// BackendSyslog forwards entries to an RFC 5424 syslog server
+	BackendSyslog BackendType = "syslog"
+	// BackendForward forwards entries to another journal LogServer
+	BackendForward BackendType = "forward"
+)
+
+// BackendConfig is the persisted description of a single registered
+// Backend. Only the fields relevant to Type are meaningful; see
+// newSyslogBackend and newForwardBackend.
+type BackendConfig struct {
+	Name string      `json:"name"`
+	Type BackendType `json:"type"`
+
+	// Network/Address/TLS* apply to BackendSyslog (network is "udp", "tcp" or
+	// "tls") and to BackendForward (network "tls" dials with TLS, anything
+	// else dials plaintext)
+	Network            string `json:"network,omitempty"`
+	Address            string `json:"address,omitempty"`
+	TLSCertFile        string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile         string `json:"tls_key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// Token authenticates this server to the downstream LogServer when Type
+	// is BackendForward
+	Token string `json:"token,omitempty"`
+
+	// Service/Instance identify this server to the downstream LogServer when
+	// Type is BackendForward
+	Service  string `json:"service,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// buildBackend constructs the Backend described by cfg
+func buildBackend(cfg BackendConfig) (Backend, error) {
+	switch cfg.Type {
+	case BackendSyslog:
+		tlsConfig, err := backendTLSConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("buildBackend: %s", err.Error())
+		}
+		return newSyslogBackend(cfg.Network, cfg.Address, tlsConfig)
+	case BackendForward:
+		tlsConfig, err := backendTLSConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("buildBackend: %s", err.Error())
+		}
+		return newForwardBackend(cfg.Address, cfg.Service, cfg.Instance, cfg.Token, tlsConfig)
+	default:
+		return nil, fmt.Errorf("buildBackend: unknown backend type %q", cfg.Type)
+	}
+}
+
+// backendTLSConfig builds the *tls.Config a syslog or forward backend dials
+// with when cfg.Network is "tls", loading a client certificate if
+// TLSCertFile/TLSKeyFile are set; it returns nil for any other network.
+func backendTLSConfig(cfg BackendConfig) (*tls.Config, error) {
+	if cfg.Network != "tls" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return tlsConfig, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("backendTLSConfig: could not load TLS certificate: %s", err.Error())
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return tlsConfig, nil
+}
+
+// backendRegistry tracks every configured Backend, fans incoming entries out
+// to all of them, and persists the configuration to disk so registered
+// backends survive a restart.
+type backendRegistry struct {
+	path string
+	slog Logger
+
+	mu       sync.RWMutex
+	configs  map[string]BackendConfig
+	backends map[string]Backend
+}
+
+// newBackendRegistry creates a backendRegistry, restoring any backends
+// persisted at path. An empty path disables persistence: the registry still
+// works, but Add/Remove changes are lost on restart.
+func newBackendRegistry(path string, slog Logger) (*backendRegistry, error) {
+	reg := &backendRegistry{
+		path:     path,
+		slog:     slog,
+		configs:  map[string]BackendConfig{},
+		backends: map[string]Backend{},
+	}
+
+	if path == "" {
+		return reg, nil
+	}
+
+	if err := fileExists(path); err != nil {
+		return nil, fmt.Errorf("newBackendRegistry: could not create remotes file: %s", err.Error())
+	}
+
+	configs, err := reg.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, cfg := range configs {
+		backend, errBuild := buildBackend(cfg)
+		if errBuild != nil {
+			slog.Warn("could not restore persisted backend", "name", name, "error", errBuild.Error())
+			continue
+		}
+		reg.configs[name] = cfg
+		reg.backends[name] = backend
+	}
+
+	return reg, nil
+}
+
+// readAll loads every BackendConfig from the registry's file; callers must
+// not hold r.mu
+func (r *backendRegistry) readAll() (map[string]BackendConfig, error) {
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("readAll: could not read remotes file: %s", err.Error())
+	}
+	if len(data) == 0 {
+		return map[string]BackendConfig{}, nil
+	}
+
+	var list []BackendConfig
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("readAll: could not parse remotes file: %s", err.Error())
+	}
+
+	configs := map[string]BackendConfig{}
+	for _, cfg := range list {
+		configs[cfg.Name] = cfg
+	}
+	return configs, nil
+}
+
+// writeAll rewrites the registry's file with the current configs; callers
+// must hold r.mu
+func (r *backendRegistry) writeAll() error {
+	names := make([]string, 0, len(r.configs))
+	for name := range r.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]BackendConfig, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.configs[name])
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writeAll: could not encode remotes: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(r.path, data, 0600); err != nil {
+		return fmt.Errorf("writeAll: could not persist remotes: %s", err.Error())
+	}
+	return nil
+}
+
+// Add registers and persists a new backend
+func (r *backendRegistry) Add(cfg BackendConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.configs[cfg.Name]; ok {
+		return fmt.Errorf("Add: backend %q already exists", cfg.Name)
+	}
+
+	backend, err := buildBackend(cfg)
+	if err != nil {
+		return fmt.Errorf("Add: %s", err.Error())
+	}
+
+	r.configs[cfg.Name] = cfg
+	r.backends[cfg.Name] = backend
+
+	if r.path != "" {
+		if err := r.writeAll(); err != nil {
+			delete(r.configs, cfg.Name)
+			delete(r.backends, cfg.Name)
+			backend.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove unregisters and closes a backend
+func (r *backendRegistry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backend, ok := r.backends[name]
+	if !ok {
+		return fmt.Errorf("Remove: no such backend %q", name)
+	}
+
+	delete(r.configs, name)
+	delete(r.backends, name)
+
+	if r.path != "" {
+		if err := r.writeAll(); err != nil {
+			return err
+		}
+	}
+
+	return backend.Close()
+}
+
+// List returns every registered backend's configuration, sorted by name
+func (r *backendRegistry) List() []BackendConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.configs))
+	for name := range r.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]BackendConfig, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.configs[name])
+	}
+	return list
+}
+
+// Write fans entry out to every registered backend, logging (but not
+// failing on) individual backend errors so one misbehaving backend cannot
+// fail RemoteLog. Backends are written one after another in the caller's
+// goroutine, so a slow backend delays RemoteLog until its Write returns.
+func (r *backendRegistry) Write(entry *logrpc.LogEntry) {
+	r.mu.RLock()
+	backends := make(map[string]Backend, len(r.backends))
+	for name, backend := range r.backends {
+		backends[name] = backend
+	}
+	r.mu.RUnlock()
+
+	for name, backend := range backends {
+		if err := backend.Write(entry); err != nil {
+			r.slog.Warn("backend write failed", "backend", name, "error", err.Error())
+		}
+	}
+}
+
+// Close closes every registered backend
+func (r *backendRegistry) Close() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for name, backend := range r.backends {
+		if err := backend.Close(); err != nil {
+			r.slog.Warn("could not close backend", "backend", name, "error", err.Error())
+		}
+	}
+}
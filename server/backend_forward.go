@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/vaitekunas/log/logrpc"
+
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+	credentials "google.golang.org/grpc/credentials"
+	metadata "google.golang.org/grpc/metadata"
+)
+
+// newForwardBackend dials another journal LogServer and returns a Backend
+// that re-submits every entry to it via logrpc.RemoteLoggerClient, the same
+// RPC a regular client uses, so chained servers see an ordinary
+// service/instance/token authorized request. tlsConfig is nil for a
+// plaintext connection.
+func newForwardBackend(address, service, instance, token string, tlsConfig *tls.Config) (*forwardBackend, error) {
+	var opts []grpc.DialOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("newForwardBackend: could not dial %s: %s", address, err.Error())
+	}
+
+	return &forwardBackend{
+		address:  address,
+		service:  service,
+		instance: instance,
+		token:    token,
+		conn:     conn,
+		client:   logrpc.NewRemoteLoggerClient(conn),
+	}, nil
+}
+
+// forwardBackend forwards log entries to another journal LogServer
+type forwardBackend struct {
+	address  string
+	service  string
+	instance string
+	token    string
+
+	conn   *grpc.ClientConn
+	client logrpc.RemoteLoggerClient
+}
+
+// Write re-submits entry to the downstream LogServer, authorizing as
+// service/instance with token, the same way any other client would
+func (b *forwardBackend) Write(entry *logrpc.LogEntry) error {
+	md := metadata.MD{
+		"service":  []string{b.service},
+		"instance": []string{b.instance},
+		"token":    []string{b.token},
+	}
+	ctx := metadata.NewContext(context.Background(), md)
+
+	if _, err := b.client.RemoteLog(ctx, entry); err != nil {
+		return fmt.Errorf("Write: could not forward log entry to %s: %s", b.address, err.Error())
+	}
+	return nil
+}
+
+// Close tears down the connection to the downstream LogServer
+func (b *forwardBackend) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vaitekunas/log/logrpc"
+)
+
+// syslogFacility is the facility every message is tagged with (local0),
+// matching the default used by logrus' syslog hook
+const syslogFacility = 16
+
+// newSyslogBackend dials a syslog server and returns a Backend that writes
+// RFC 5424 formatted messages to it, mapping entry.Service/entry.Instance to
+// the APP-NAME/MSGID fields the way logrus' syslog hook maps its own fields.
+// network is "udp", "tcp" or "tls"; tlsConfig is only used when network is "tls".
+func newSyslogBackend(network, address string, tlsConfig *tls.Config) (*syslogBackend, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "journal"
+	}
+
+	b := &syslogBackend{network: network, address: address, tlsConfig: tlsConfig, hostname: hostname}
+	if err := b.dial(); err != nil {
+		return nil, fmt.Errorf("newSyslogBackend: %s", err.Error())
+	}
+
+	return b, nil
+}
+
+// syslogBackend forwards log entries to a syslog server as RFC 5424 messages
+type syslogBackend struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	hostname  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// dial (re)establishes the backend's connection; callers must hold b.mu
+func (b *syslogBackend) dial() error {
+	var conn net.Conn
+	var err error
+
+	switch b.network {
+	case "tls":
+		conn, err = tls.Dial("tcp", b.address, b.tlsConfig)
+	case "tcp":
+		conn, err = net.Dial("tcp", b.address)
+	default:
+		conn, err = net.Dial("udp", b.address)
+	}
+	if err != nil {
+		return fmt.Errorf("dial: could not connect to syslog server %s: %s", b.address, err.Error())
+	}
+
+	b.conn = conn
+	return nil
+}
+
+// syslogSeverity maps a parsed log level to its RFC 5424 severity, defaulting
+// to "informational" for unrecognized or empty levels
+func syslogSeverity(level string) int {
+	switch strings.ToUpper(level) {
+	case "ERROR":
+		return 3 // error
+	case "WARN":
+		return 4 // warning
+	case "INFO":
+		return 6 // informational
+	case "DEBUG", "TRACE":
+		return 7 // debug
+	default:
+		return 6 // informational
+	}
+}
+
+// Write sends entry to the syslog server as a single RFC 5424 message,
+// reconnecting once if the connection was lost
+func (b *syslogBackend) Write(entry *logrpc.LogEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		if err := b.dial(); err != nil {
+			return err
+		}
+	}
+
+	if err := b.send(entry); err != nil {
+		b.conn.Close()
+		b.conn = nil
+
+		if errRedial := b.dial(); errRedial != nil {
+			return fmt.Errorf("Write: %s", err.Error())
+		}
+		if err := b.send(entry); err != nil {
+			b.conn.Close()
+			b.conn = nil
+			return fmt.Errorf("Write: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// send writes a single RFC 5424 message to the current connection; callers
+// must hold b.mu
+func (b *syslogBackend) send(entry *logrpc.LogEntry) error {
+	pri := syslogFacility*8 + syslogSeverity(parseLevel(entry.Entry))
+
+	appName := entry.Service
+	if appName == "" {
+		appName = "-"
+	}
+	msgID := entry.Instance
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s\n",
+		pri, time.Now().UTC().Format(time.RFC3339), b.hostname, appName, os.Getpid(), msgID, entry.Entry)
+
+	if _, err := b.conn.Write([]byte(message)); err != nil {
+		return fmt.Errorf("could not send syslog message: %s", err.Error())
+	}
+	return nil
+}
+
+// Close closes the backend's connection
+func (b *syslogBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		return nil
+	}
+
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ListenerType selects the transport a ListenerConfig binds to
+type ListenerType string
+
+const (
+	// ListenerTCP is a plain TCP listener serving gRPC
+	ListenerTCP ListenerType = "tcp"
+	// ListenerUnix is a unix-socket listener serving the management console
+	ListenerUnix ListenerType = "unix"
+	// ListenerTLS is a TLS-terminated TCP listener serving gRPC
+	ListenerTLS ListenerType = "tls"
+)
+
+// ProxyProtocolVersion selects the HAProxy PROXY protocol encoding a
+// listener expects on every new connection, if any
+type ProxyProtocolVersion string
+
+const (
+	// ProxyProtocolNone disables PROXY protocol parsing (the default)
+	ProxyProtocolNone ProxyProtocolVersion = ""
+	// ProxyProtocolV1 expects the text encoding ("PROXY TCP4 ...\r\n")
+	ProxyProtocolV1 ProxyProtocolVersion = "v1"
+	// ProxyProtocolV2 expects the binary encoding (12-byte magic signature)
+	ProxyProtocolV2 ProxyProtocolVersion = "v2"
+)
+
+// ListenerConfig declares a single endpoint for New to listen on. Several
+// listeners of different types may be declared so a LogServer can, for
+// example, serve gRPC on both a plain TCP port and a TLS port behind a
+// load balancer terminating PROXY protocol.
+type ListenerConfig struct {
+	Type          ListenerType         `yaml:"type"`
+	Address       string               `yaml:"address"`
+	ProxyProtocol ProxyProtocolVersion `yaml:"proxy_protocol,omitempty"`
+
+	// TLSCertFile/TLSKeyFile are required when Type is ListenerTLS
+	TLSCertFile string `yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `yaml:"tls_key_file,omitempty"`
+}
+
+// LoadConfig reads and parses a YAML server configuration from path
+func LoadConfig(path string) (*Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfig: could not read %s: %s", path, err.Error())
+	}
+
+	config := &Config{}
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("LoadConfig: could not parse %s: %s", path, err.Error())
+	}
+
+	return config, nil
+}
+
+// resolveListeners returns config.Listeners, or - if it is empty - the
+// single unix and single tcp listener implied by the legacy flat
+// Host/Port/UnixSockPath fields, so configs written before the listeners
+// array existed keep working unchanged.
+func resolveListeners(config *Config) []ListenerConfig {
+	if len(config.Listeners) > 0 {
+		return config.Listeners
+	}
+
+	listeners := []ListenerConfig{}
+	if config.UnixSockPath != "" {
+		listeners = append(listeners, ListenerConfig{Type: ListenerUnix, Address: config.UnixSockPath})
+	}
+	listeners = append(listeners, ListenerConfig{Type: ListenerTCP, Address: fmt.Sprintf("%s:%d", config.Host, config.Port)})
+
+	return listeners
+}
+
+// newListener opens the net.Listener described by lc, wrapping it to parse
+// and strip a PROXY protocol header off every accepted connection if
+// lc.ProxyProtocol is set
+func newListener(lc ListenerConfig) (net.Listener, error) {
+	var lis net.Listener
+	var err error
+
+	switch lc.Type {
+	case ListenerUnix:
+		lis, err = net.Listen("unix", lc.Address)
+	case ListenerTCP:
+		lis, err = net.Listen("tcp", lc.Address)
+	case ListenerTLS:
+		return newTLSListener(lc)
+	default:
+		return nil, fmt.Errorf("newListener: unknown listener type %q", lc.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.ProxyProtocol != ProxyProtocolNone {
+		lis = newProxyProtocolListener(lis, lc.ProxyProtocol)
+	}
+
+	return lis, nil
+}
+
+// newTLSListener opens a raw TCP listener for lc and, if lc.ProxyProtocol is
+// set, wraps it with a PROXY protocol listener *before* layering the TLS
+// handshake on top. PROXY protocol bytes arrive on the wire ahead of the TLS
+// record layer, so handshaking first (e.g. via tls.Listen) would feed them
+// into the handshake and break every connection; stripping the header off
+// the raw connection first, then handshaking on what's left, is the only
+// order that works.
+func newTLSListener(lc ListenerConfig) (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(lc.TLSCertFile, lc.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("newTLSListener: could not load TLS certificate: %s", err.Error())
+	}
+
+	lis, err := net.Listen("tcp", lc.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.ProxyProtocol != ProxyProtocolNone {
+		lis = newProxyProtocolListener(lis, lc.ProxyProtocol)
+	}
+
+	return tls.NewListener(lis, &tls.Config{Certificates: []tls.Certificate{cert}}), nil
+}
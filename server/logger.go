@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level represents the severity of a structured log entry
+type Level int
+
+// Severity levels, from least to most severe
+const (
+	Trace Level = iota
+	Debug
+	Info
+	Warn
+	Error
+)
+
+// String returns the upper-case name of the level
+func (lv Level) String() string {
+	switch lv {
+	case Trace:
+		return "TRACE"
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseLevel parses a level name (case-insensitive); unknown names fall back to Info
+func ParseLevel(name string) Level {
+	switch strings.ToLower(name) {
+	case "trace":
+		return Trace
+	case "debug":
+		return Debug
+	case "warn", "warning":
+		return Warn
+	case "error":
+		return Error
+	default:
+		return Info
+	}
+}
+
+// Logger is a leveled, structured logger in the vein of go-hclog. Every
+// internal server message (accept loop errors, gRPC serve failures, unix
+// socket accept errors, killswitch events, console command dispatch) is
+// routed through an implementation of this interface instead of bare
+// fmt.Printf, carrying key/value context such as service, instance and
+// remote_addr along with it.
+type Logger interface {
+	Trace(msg string, args ...interface{})
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+
+	// With returns a derived Logger that prepends key/value pairs (args
+	// alternating key, value, key, value, ...) to every entry it emits
+	With(args ...interface{}) Logger
+}
+
+// consoleLogger writes human-friendly, colorized-free text lines
+type consoleLogger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields []keyval
+	name   string
+}
+
+// jsonLogger writes one JSON object per line
+type jsonLogger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields []keyval
+	name   string
+}
+
+type keyval struct {
+	key   string
+	value interface{}
+}
+
+// NewLogger creates a Logger named name, filtered to level, writing to out in
+// either "console" or "json" format (console is the default for any other value)
+func NewLogger(name string, level Level, format string, out io.Writer) Logger {
+	if out == nil {
+		out = os.Stderr
+	}
+
+	mu := &sync.Mutex{}
+	if strings.ToLower(format) == "json" {
+		return &jsonLogger{mu: mu, out: out, level: level, name: name}
+	}
+	return &consoleLogger{mu: mu, out: out, level: level, name: name}
+}
+
+// pairs converts alternating key/value args into keyval pairs, ignoring a
+// trailing key with no matching value
+func pairs(args []interface{}) []keyval {
+	kv := make([]keyval, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprintf("%v", args[i])
+		}
+		kv = append(kv, keyval{key: key, value: args[i+1]})
+	}
+	return kv
+}
+
+func (l *consoleLogger) log(lv Level, msg string, args ...interface{}) {
+	if lv < l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := &strings.Builder{}
+	b.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	fmt.Fprintf(b, " [%-5s] ", lv.String())
+	if l.name != "" {
+		fmt.Fprintf(b, "%s: ", l.name)
+	}
+	b.WriteString(msg)
+
+	for _, kv := range append(append([]keyval{}, l.fields...), pairs(args)...) {
+		fmt.Fprintf(b, " %s=%v", kv.key, kv.value)
+	}
+	b.WriteString("\n")
+
+	io.WriteString(l.out, b.String())
+}
+
+func (l *consoleLogger) Trace(msg string, args ...interface{}) { l.log(Trace, msg, args...) }
+func (l *consoleLogger) Debug(msg string, args ...interface{}) { l.log(Debug, msg, args...) }
+func (l *consoleLogger) Info(msg string, args ...interface{})  { l.log(Info, msg, args...) }
+func (l *consoleLogger) Warn(msg string, args ...interface{})  { l.log(Warn, msg, args...) }
+func (l *consoleLogger) Error(msg string, args ...interface{}) { l.log(Error, msg, args...) }
+
+func (l *consoleLogger) With(args ...interface{}) Logger {
+	return &consoleLogger{
+		mu:     l.mu,
+		out:    l.out,
+		level:  l.level,
+		name:   l.name,
+		fields: append(append([]keyval{}, l.fields...), pairs(args)...),
+	}
+}
+
+func (l *jsonLogger) log(lv Level, msg string, args ...interface{}) {
+	if lv < l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := map[string]interface{}{
+		"@timestamp": time.Now().Format(time.RFC3339Nano),
+		"@level":     lv.String(),
+		"@message":   msg,
+	}
+	if l.name != "" {
+		entry["@module"] = l.name
+	}
+	for _, kv := range append(append([]keyval{}, l.fields...), pairs(args)...) {
+		entry[kv.key] = kv.value
+	}
+
+	enc, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.out.Write(append(enc, '\n'))
+}
+
+func (l *jsonLogger) Trace(msg string, args ...interface{}) { l.log(Trace, msg, args...) }
+func (l *jsonLogger) Debug(msg string, args ...interface{}) { l.log(Debug, msg, args...) }
+func (l *jsonLogger) Info(msg string, args ...interface{})  { l.log(Info, msg, args...) }
+func (l *jsonLogger) Warn(msg string, args ...interface{})  { l.log(Warn, msg, args...) }
+func (l *jsonLogger) Error(msg string, args ...interface{}) { l.log(Error, msg, args...) }
+
+func (l *jsonLogger) With(args ...interface{}) Logger {
+	return &jsonLogger{
+		mu:     l.mu,
+		out:    l.out,
+		level:  l.level,
+		name:   l.name,
+		fields: append(append([]keyval{}, l.fields...), pairs(args)...),
+	}
+}
@@ -1,18 +1,19 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"github.com/vaitekunas/log"
 	"github.com/vaitekunas/log/logrpc"
 	"net"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
 	context "golang.org/x/net/context"
 	grpc "google.golang.org/grpc"
 	metadata "google.golang.org/grpc/metadata"
+	peer "google.golang.org/grpc/peer"
 )
 
 // killswitch is used to close all goroutines
@@ -35,37 +36,128 @@ type LogServer struct {
 	server *grpc.Server   // gRPC server
 	wg     sync.WaitGroup // Waitgroup for the unix and grpc listeners
 
-	unixSockPath string       // Path to the unix socket file
-	listenUnix   net.Listener // Unix-socket listener (unix)
-	listenTCP    net.Listener // TCP listener (grpc)
+	listeners []net.Listener // Every listener opened by New (unix, tcp and tls)
 
 	killswitches []killswitch
 
-	tokens   map[string]string // Authorization tokens map[service]map[instance]token
-	quitChan chan bool         // Internal kill switch
+	mu     sync.RWMutex
+	store  TokenStore              // Backing token store (defaults to FileTokenStore)
+	tokens map[string]*TokenRecord // In-memory cache of store, keyed by service/instance, kept in sync via Watch
+	stats  map[string]*Statistic   // Per service/instance statistics, keyed by service/instance
+
+	recentBufferSize int // Size of each service/instance's ring buffer of recent entries
+	logsMu           sync.Mutex
+	logs             map[string]*ringBuffer // Per service/instance recent-entry ring buffers, keyed like stats
+
+	backends *backendRegistry // Additional destinations (syslog, chained servers) fanned out to on every entry
+
+	slog Logger // Structured, leveled logger for internal server messages and audit entries
+
+	quitChan chan bool // Internal kill switch
+}
+
+// Log returns the server's structured logger
+func (l *LogServer) Log() Logger {
+	return l.slog
 }
 
 // RemoteLog handles incoming remote logs
 func (l *LogServer) RemoteLog(ctx context.Context, logEntry *logrpc.LogEntry) (*logrpc.Nothing, error) {
-	// TODO: register last IP and amount of logs parsed
 	if err := l.logger.RawEntry(logEntry.Entry); err != nil {
+		l.slog.Error("could not process raw log entry", "error", err.Error())
 		return nil, fmt.Errorf("RemoteLog: could not process raw log: %s", err.Error())
 	}
+
+	l.recordEntry(ctx, logEntry.Entry)
+	l.backends.Write(logEntry)
+
+	l.slog.Debug("processed remote log entry")
 	return nil, nil
 }
 
+// recordEntry updates the Statistic and recent-entry ring buffer of the
+// service/instance identified by ctx's metadata with a freshly received raw
+// log line, ignoring calls whose metadata does not identify a known
+// service/instance
+func (l *LogServer) recordEntry(ctx context.Context, raw string) {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return
+	}
+	svc, okSvc := md["service"]
+	inst, okInst := md["instance"]
+	if !okSvc || len(svc) != 1 || !okInst || len(inst) != 1 {
+		return
+	}
+
+	key := getCleanKey(svc[0], inst[0])
+	now := time.Now()
+	entry := LogEntry{Timestamp: now, Level: parseLevel(raw), Size: len(raw), Raw: raw}
+
+	l.mu.Lock()
+	stat, ok := l.stats[key]
+	if ok {
+		stat.LastIP = hostOnly(remoteAddr(ctx))
+		stat.LastActive = now
+		hour := now.Hour()
+		stat.LogsParsed[hour]++
+		stat.LogsParsedBytes[hour] += int64(entry.Size)
+	}
+	l.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	l.bufferFor(key).add(entry)
+}
+
+// bufferFor returns the ring buffer for key, creating it on first use
+func (l *LogServer) bufferFor(key string) *ringBuffer {
+	l.logsMu.Lock()
+	defer l.logsMu.Unlock()
+
+	buf, ok := l.logs[key]
+	if !ok {
+		buf = newRingBuffer(l.recentBufferSize)
+		l.logs[key] = buf
+	}
+	return buf
+}
+
+// remoteAddr extracts the caller's address from ctx, or "unknown" if absent
+func remoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// hostOnly strips the port off a "host:port" address, returning addr unchanged if it has none
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 // Authorize is a gRPC interceptor that authorizes incoming RPCs
 func (l *LogServer) Authorize(ctx context.Context) error {
 
+	audit := l.slog.With("remote_addr", remoteAddr(ctx))
+
 	// Verify presence of metadata
 	md, ok := metadata.FromContext(ctx)
 	if !ok {
+		audit.Warn("rejected rpc: missing metadata")
 		return fmt.Errorf("Authorize: missing metadata")
 	}
 
 	// Verify that all required items are available
 	for _, key := range []string{"service", "instance", "token"} {
 		if slice, okKey := md[key]; !okKey || len(slice) != 1 {
+			audit.Warn("rejected rpc: missing required field", "field", key)
 			return fmt.Errorf("Authorize: missing %s", key)
 		}
 	}
@@ -73,16 +165,28 @@ func (l *LogServer) Authorize(ctx context.Context) error {
 	// Extract the real token
 	service := md["service"][0]
 	instance := md["instance"][0]
-	key := fmt.Sprintf("%s/%s", strings.ToLower(service), strings.ToLower(instance))
+	key := getCleanKey(service, instance)
 	token := md["token"][0]
+	audit = audit.With("service", service, "instance", instance)
 
-	realToken, ok := l.tokens[key]
+	l.mu.RLock()
+	record, ok := l.tokens[key]
+	l.mu.RUnlock()
 	if !ok {
+		audit.Warn("rejected rpc: unknown service/instance")
 		return fmt.Errorf("Authorize: unknown service/instance")
 	}
 
-	// Authorize
-	if realToken != token {
+	// Authorize against either the current token or, during a rotation's
+	// grace period, the previous one
+	now := time.Now()
+	switch {
+	case record.Token == token && !record.Expired(now):
+		audit.Debug("authorized rpc")
+	case record.prevValid(now) && record.PrevToken == token:
+		audit.Warn("authorized rpc with grace-period token; client should pick up the rotated token")
+	default:
+		audit.Warn("rejected rpc: bad token")
 		return fmt.Errorf("Authorize: bad token")
 	}
 
@@ -104,37 +208,83 @@ func (l *LogServer) GetBanner() string {
 	return l.banner
 }
 
+// closeListeners closes every listener opened so far; used to unwind a
+// partially-initialized LogServer when New fails partway through
+func (l *LogServer) closeListeners() {
+	for _, lis := range l.listeners {
+		lis.Close()
+	}
+}
+
 // Quit stops the server and all goroutines
 func (l *LogServer) Quit() {
 
+	l.slog.Info("shutting down", "killswitches", len(l.killswitches))
 	for _, quitChan := range l.killswitches {
 		quitChan <- true
 	}
 
-	if err := l.listenUnix.Close(); err != nil {
-		fmt.Printf("Quit: could not close unix-socket listener: %s\n", err.Error())
+	for _, lis := range l.listeners {
+		if err := lis.Close(); err != nil {
+			l.slog.Error("could not close listener", "address", lis.Addr().String(), "error", err.Error())
+		}
 	}
 
-	if err := l.listenTCP.Close(); err != nil {
-		fmt.Printf("Quit: could not close tcp-socket listener: %s\n", err.Error())
+	l.backends.Close()
+
+	if err := l.store.Close(); err != nil {
+		l.slog.Error("could not close token store", "error", err.Error())
 	}
 
 	l.wg.Wait()
 }
 
-// Config contains all the configuration for the remote logger
+// Config contains all the configuration for the remote logger. It is
+// YAML-loadable via LoadConfig.
 type Config struct {
 
-	// Remote logger config
-	Host         string
-	Port         int
-	UnixSockPath string
-	TokenPath    string
-	StatsPath    string
-	Banner       string
+	// Remote logger config. Host/Port/UnixSockPath are the legacy flat
+	// single-listener fields; when Listeners is empty, New translates them
+	// into a single synthetic unix listener and a single synthetic tcp
+	// listener, so existing configs keep working unchanged.
+	Host         string `yaml:"host,omitempty"`
+	Port         int    `yaml:"port,omitempty"`
+	UnixSockPath string `yaml:"unix_sock_path,omitempty"`
+	TokenPath    string `yaml:"token_path,omitempty"`
+	StatsPath    string `yaml:"stats_path,omitempty"`
+	Banner       string `yaml:"banner,omitempty"`
+
+	// Listeners declares every endpoint New listens on. Each entry's type
+	// (tcp/unix/tls) and optional proxy_protocol (v1/v2) are independent, so
+	// a LogServer can, for example, terminate TLS on one listener and accept
+	// PROXY-protocol-wrapped connections from a load balancer on another.
+	Listeners []ListenerConfig `yaml:"listeners,omitempty"`
+
+	// TokenStore backs the server's authorization tokens. When nil, New
+	// falls back to a FileTokenStore rooted at TokenPath, so multiple
+	// LogServer replicas sharing a TokenStore (e.g. EtcdTokenStore or
+	// ConsulTokenStore) can run behind a load balancer and stay in sync.
+	TokenStore TokenStore `yaml:"-"`
+
+	// LogLevel filters the server's own structured log messages ("trace",
+	// "debug", "info", "warn", "error"); defaults to "info"
+	LogLevel string `yaml:"log_level,omitempty"`
+
+	// LogFormat selects the structured log formatter: "console" (default)
+	// or "json"
+	LogFormat string `yaml:"log_format,omitempty"`
+
+	// RecentBufferSize is how many recent entries each service/instance's
+	// ring buffer retains for the logs.tail command; defaults to 1024
+	RecentBufferSize int `yaml:"recent_buffer_size,omitempty"`
+
+	// BackendsPath persists the remote.add/remote.remove backend registry
+	// (syslog and chained-server forwarding) so it survives a restart. An
+	// empty path keeps the registry in memory only.
+	BackendsPath string `yaml:"backends_path,omitempty"`
 
 	// Local logger config
-	LoggerConfig *log.Config
+	LoggerConfig *log.Config `yaml:"-"`
 }
 
 // New creates a new logserver instance
@@ -142,29 +292,13 @@ func New(config *Config) (*LogServer, error) {
 
 	// Instantiate remote logserver
 	rLogger := &LogServer{banner: config.Banner}
+	rLogger.slog = NewLogger("journal", ParseLevel(config.LogLevel), config.LogFormat, os.Stderr).
+		With("service", "journal")
 
-	// Listen on to the unix socket
-	listenUnix, err := net.Listen("unix", config.UnixSockPath)
-	if err != nil {
-		return nil, fmt.Errorf("New: could not listen on the unix socket: %s", err.Error())
-	}
-
-	// Serve socket requests
+	// Serve unix-socket requests (shared across every unix listener declared
+	// in config.Listeners)
 	quitChan, connChan := make(chan bool, 1), make(chan net.Conn, 1)
 	rLogger.killswitches = append(rLogger.killswitches, quitChan)
-
-	// Listen for incoming unix connections
-	go func() {
-		for {
-			fd, errUnix := listenUnix.Accept()
-			if errUnix != nil {
-				continue
-			}
-			connChan <- fd
-		}
-	}()
-
-	// Process unix connections
 	go func() {
 	Loop:
 		for {
@@ -177,13 +311,6 @@ func New(config *Config) (*LogServer, error) {
 		}
 	}()
 
-	// Listen on tcp
-	listenTCP, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
-	if err != nil {
-		listenUnix.Close()
-		return nil, fmt.Errorf("New: could not listen on tcp socket: %s", err.Error())
-	}
-
 	// Create Auth interceptor
 	intercept := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		if errAuth := rLogger.Authorize(ctx); errAuth != nil {
@@ -193,42 +320,136 @@ func New(config *Config) (*LogServer, error) {
 	}
 
 	// Put everything together
-	rLogger.unixSockPath = config.UnixSockPath
-	rLogger.listenUnix = listenUnix
-	rLogger.listenTCP = listenTCP
 	rLogger.server = grpc.NewServer(grpc.UnaryInterceptor(intercept))
-	rLogger.tokens = make(map[string]string)
+	logrpc.RegisterRemoteLoggerServer(rLogger.server, rLogger)
+	rLogger.tokens = make(map[string]*TokenRecord)
+	rLogger.stats = make(map[string]*Statistic)
+	rLogger.logs = make(map[string]*ringBuffer)
+	rLogger.recentBufferSize = config.RecentBufferSize
 	rLogger.quitChan = make(chan bool, 1)
 
-	// Serve gRPC requests
-	logrpc.RegisterRemoteLoggerServer(rLogger.server, rLogger)
-	quitChan, failChan := make(chan bool, 1), make(chan error, 1)
-	rLogger.killswitches = append(rLogger.killswitches, quitChan)
-	go func() {
-		if errTCP := rLogger.server.Serve(listenTCP); errTCP != nil {
-			failChan <- errTCP
+	backends, errBackends := newBackendRegistry(config.BackendsPath, rLogger.slog)
+	if errBackends != nil {
+		rLogger.closeListeners()
+		return nil, fmt.Errorf("New: could not load backend registry: %s", errBackends.Error())
+	}
+	rLogger.backends = backends
+
+	// Open every configured listener (falling back to a single synthetic
+	// unix and tcp listener when Listeners is empty), wrapping PROXY
+	// protocol listeners so Authorize/RemoteLog see the real client address
+	listenerConfigs := resolveListeners(config)
+	for _, lc := range listenerConfigs {
+		lis, errListen := newListener(lc)
+		if errListen != nil {
+			rLogger.closeListeners()
+			return nil, fmt.Errorf("New: could not open %s listener on %s: %s", lc.Type, lc.Address, errListen.Error())
 		}
-	}()
+		rLogger.listeners = append(rLogger.listeners, lis)
+
+		switch lc.Type {
+		case ListenerUnix:
+			go func(lis net.Listener) {
+				for {
+					fd, errAccept := lis.Accept()
+					if errAccept != nil {
+						if errors.Is(errAccept, net.ErrClosed) {
+							// Quit() closed this listener; nothing left to accept.
+							return
+						}
+						rLogger.slog.Warn("unix socket accept error", "address", lis.Addr().String(), "error", errAccept.Error())
+						continue
+					}
+					connChan <- fd
+				}
+			}(lis)
+		case ListenerTCP, ListenerTLS:
+			go func(lis net.Listener) {
+				if errServe := rLogger.server.Serve(lis); errServe != nil && errServe != grpc.ErrServerStopped {
+					rLogger.slog.Error("could not serve requests", "address", lis.Addr().String(), "error", errServe.Error())
+					rLogger.Quit()
+					os.Exit(1)
+				}
+			}(lis)
+		}
+	}
+
+	// Fall back to the file-backed token store
+	store := config.TokenStore
+	if store == nil {
+		var errStore error
+		store, errStore = NewFileTokenStore(config.TokenPath)
+		if errStore != nil {
+			rLogger.closeListeners()
+			return nil, fmt.Errorf("New: could not open token store: %s", errStore.Error())
+		}
+	}
+	rLogger.store = store
 
-	// Quit if gRPC server fails
+	// Load the current token set into the in-memory cache
+	tokens, err := store.List()
+	if err != nil {
+		rLogger.closeListeners()
+		return nil, fmt.Errorf("New: could not load tokens: %s", err.Error())
+	}
+	for key, value := range tokens {
+		record, errDecode := decodeTokenRecord(value)
+		if errDecode != nil {
+			rLogger.closeListeners()
+			return nil, fmt.Errorf("New: could not decode token record for %s: %s", key, errDecode.Error())
+		}
+		rLogger.tokens[key] = &record
+		service, instance, _ := splitKey(key)
+		rLogger.stats[key] = &Statistic{Service: service, Instance: instance}
+	}
+
+	// Keep the in-memory cache in sync with the store, including changes
+	// made by other LogServer replicas sharing the same TokenStore
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	watchChan := store.Watch(watchCtx)
+	watchQuit := make(chan bool, 1)
+	rLogger.killswitches = append(rLogger.killswitches, watchQuit)
 	go func() {
-		select {
-		case errTCP := <-failChan:
-			if errTCP != nil {
-				fmt.Printf("New: could not serve TCP requests: %s\n", errTCP.Error())
-				rLogger.Quit()
-				os.Exit(1)
+		defer cancelWatch()
+		for {
+			select {
+			case event, ok := <-watchChan:
+				if !ok {
+					return
+				}
+				rLogger.mu.Lock()
+				switch event.Type {
+				case TokenEventPut:
+					if record, errDecode := decodeTokenRecord(event.Token); errDecode == nil {
+						rLogger.tokens[event.Key] = &record
+						if _, ok := rLogger.stats[event.Key]; !ok {
+							service, instance, _ := splitKey(event.Key)
+							rLogger.stats[event.Key] = &Statistic{Service: service, Instance: instance}
+						}
+					} else {
+						rLogger.slog.Warn("could not decode watched token record", "key", event.Key, "error", errDecode.Error())
+					}
+				case TokenEventDelete:
+					delete(rLogger.tokens, event.Key)
+				}
+				rLogger.mu.Unlock()
+			case <-watchQuit:
+				return
 			}
-		case <-time.After(10 * time.Second):
 		}
 	}()
 
-	// Wait for gRPC server to start up
-	//rLogger.wg.Add(1)
+	// Reap expired tokens and grace-period leftovers from memory and the store
+	reapQuit := make(chan bool, 1)
+	rLogger.killswitches = append(rLogger.killswitches, reapQuit)
+	go rLogger.reapExpiredTokens(reapQuit)
+
+	// Stop the gRPC server on shutdown
+	stopChan := make(chan bool, 1)
+	rLogger.killswitches = append(rLogger.killswitches, stopChan)
 	go func() {
-		<-quitChan
+		<-stopChan
 		rLogger.server.Stop()
-		//rLogger.wg.Done()
 	}()
 
 	// Instantiate logger
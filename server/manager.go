@@ -21,6 +21,9 @@ type ManagementConsole interface {
 	// CmdLogsList list all available logfiles and their archives
 	CmdLogsList(unixsock.Args) *unixsock.Response
 
+	// CmdLogsTail displays the most recent log entries of a service/instance
+	CmdLogsTail(unixsock.Args) *unixsock.Response
+
 	// CmdRemoteAdd adds a remote backend
 	CmdRemoteAdd(unixsock.Args) *unixsock.Response
 
@@ -45,6 +48,13 @@ type ManagementConsole interface {
 	// CmdTokensRemoveService removes the token of all instances of a service
 	CmdTokensRemoveService(unixsock.Args) *unixsock.Response
 
+	// CmdTokensRotate issues a new token for a service/instance, keeping the
+	// previous one valid for a grace period
+	CmdTokensRotate(unixsock.Args) *unixsock.Response
+
+	// CmdTokensExpire immediately invalidates the token of a service/instance
+	CmdTokensExpire(unixsock.Args) *unixsock.Response
+
 	// Execute is the executor of management console commands
 	Execute(string, unixsock.Args) *unixsock.Response
 }
@@ -66,7 +76,7 @@ type managementConsole struct {
 // Execute is the executor of management console commands
 func (m *managementConsole) Execute(cmd string, args unixsock.Args) *unixsock.Response {
 
-	fmt.Printf(" ▶ [%s] Received command [%s]\n", time.Now().Format("2006-01-02 15:04:05"), bold(strings.ToLower(cmd)))
+	m.logserver.Log().Info("received console command", "cmd", strings.ToLower(cmd))
 
 	switch strings.ToLower(cmd) {
 	case "statistics":
@@ -77,12 +87,18 @@ func (m *managementConsole) Execute(cmd string, args unixsock.Args) *unixsock.Re
 		return m.CmdTokensRemoveInstance(args)
 	case "tokens.remove.service":
 		return m.CmdTokensRemoveService(args)
+	case "tokens.rotate":
+		return m.CmdTokensRotate(args)
+	case "tokens.expire":
+		return m.CmdTokensExpire(args)
 	case "tokens.list.instances":
 		return m.CmdTokensListInstances(args)
 	case "tokens.list.services":
 		return m.CmdTokensListServices(args)
 	case "logs.list":
 		return m.CmdLogsList(args)
+	case "logs.tail":
+		return m.CmdLogsTail(args)
 	case "remote.add":
 		return m.CmdRemoteAdd(args)
 	case "remote.remove":
@@ -126,7 +142,52 @@ var respMissingArgs = &unixsock.Response{
 
 // CmdStatistics displays various log-related statistics
 func (m *managementConsole) CmdStatistics(args unixsock.Args) *unixsock.Response {
-	return &unixsock.Response{}
+
+	// m.logserver.stats is also written by recordEntry on every received log
+	// entry, so it must only be read under m.logserver.mu
+	m.logserver.mu.RLock()
+	keys := make([]string, 0, len(m.logserver.stats))
+	stats := make(map[string]Statistic, len(m.logserver.stats))
+	for key, stat := range m.logserver.stats {
+		keys = append(keys, key)
+		stats[key] = *stat
+	}
+	m.logserver.mu.RUnlock()
+	sort.Strings(keys)
+
+	table := lentele.New("Service", "Instance", "Logs (24h)", "Bytes (24h)", "Last IP", "Last active")
+	table.AddTitle("Log statistics")
+
+	for _, key := range keys {
+		stat := stats[key]
+
+		var logsParsed, bytesParsed int64
+		for _, v := range stat.LogsParsed {
+			logsParsed += v
+		}
+		for _, v := range stat.LogsParsedBytes {
+			bytesParsed += v
+		}
+
+		lastActive := "never"
+		if !stat.LastActive.IsZero() {
+			lastActive = stat.LastActive.Format("2006-01-02 15:04:05")
+		}
+		lastIP := stat.LastIP
+		if lastIP == "" {
+			lastIP = "???"
+		}
+
+		table.AddRow("").Insert(stat.Service, stat.Instance, logsParsed, bytesParsed, lastIP, lastActive)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	table.Render(buf, false, true, false, lentele.LoadTemplate("classic"))
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: buf.String(),
+	}
 }
 
 // CmdTokensAdd adds a new token for a service/instance
@@ -179,6 +240,85 @@ func (m *managementConsole) CmdTokensRemoveService(args unixsock.Args) *unixsock
 	return &unixsock.Response{}
 }
 
+// defaultRotationGrace is the grace period applied to tokens.rotate when the
+// caller does not supply one
+const defaultRotationGrace = 24 * time.Hour
+
+// CmdTokensRotate issues a new token for a service/instance, keeping the
+// previous one valid for grace_seconds (default defaultRotationGrace)
+func (m *managementConsole) CmdTokensRotate(args unixsock.Args) *unixsock.Response {
+
+	// Validate arguments
+	required := []arg{
+		arg{"service", reflect.String},
+		arg{"instance", reflect.String},
+	}
+	if !validArguments(args, required) {
+		return respMissingArgs
+	}
+
+	service := args["service"].(string)
+	instance := args["instance"].(string)
+
+	gracePeriod := defaultRotationGrace
+	if seconds, ok := args["grace_seconds"].(float64); ok {
+		gracePeriod = time.Duration(seconds) * time.Second
+	}
+
+	token, err := m.logserver.RotateToken(service, instance, gracePeriod)
+	if err != nil {
+		return &unixsock.Response{
+			Status: "failure",
+			Error:  fmt.Errorf("Could not rotate token: %s", err.Error()).Error(),
+		}
+	}
+
+	bold := func(v interface{}) interface{} {
+		return color.New(color.Bold).Sprint(v)
+	}
+
+	// Prepare table
+	table := lentele.New("Service", "Instance", "New token", "Grace period")
+	table.AddTitle(fmt.Sprintf("Token rotated for %s/%s", service, instance))
+	table.AddRow("").Insert(service, instance, token, gracePeriod.String()).Modify(bold, "New token")
+	buf := bytes.NewBuffer([]byte{})
+	table.Render(buf, false, true, false, lentele.LoadTemplate("classic"))
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: buf.String(),
+	}
+}
+
+// CmdTokensExpire immediately invalidates the current (and any grace-period)
+// token of a service/instance, without removing its statistics
+func (m *managementConsole) CmdTokensExpire(args unixsock.Args) *unixsock.Response {
+
+	// Validate arguments
+	required := []arg{
+		arg{"service", reflect.String},
+		arg{"instance", reflect.String},
+	}
+	if !validArguments(args, required) {
+		return respMissingArgs
+	}
+
+	service := args["service"].(string)
+	instance := args["instance"].(string)
+
+	if err := m.logserver.ExpireToken(service, instance); err != nil {
+		return &unixsock.Response{
+			Status: "failure",
+			Error:  fmt.Errorf("Could not expire token: %s", err.Error()).Error(),
+		}
+	}
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: fmt.Sprintf("Token for %s/%s expired", service, instance),
+	}
+}
+
 // CmdTokensListInstances lists all permitted instances of a service
 func (m *managementConsole) CmdTokensListInstances(args unixsock.Args) *unixsock.Response {
 
@@ -194,11 +334,20 @@ func (m *managementConsole) CmdTokensListInstances(args unixsock.Args) *unixsock
 	// Identify service
 	service := strings.ToLower(args["service"].(string))
 
+	// m.logserver.tokens is also written by the token-store watch goroutine,
+	// so it must only be read under m.logserver.mu
+	m.logserver.mu.RLock()
+	tokens := make(map[string]string, len(m.logserver.tokens))
+	for key, record := range m.logserver.tokens {
+		tokens[key] = record.Token
+	}
+	m.logserver.mu.RUnlock()
+
 	// Prepare table
 	table := lentele.New("Instance", "Token", "Last IP", "Logs parsed")
 	table.AddTitle(fmt.Sprintf("Service %s: permited instances", service))
 
-	for key, token := range m.logserver.tokens {
+	for key, token := range tokens {
 		parts := strings.Split(key, "/")
 		if len(parts) != 2 {
 			continue
@@ -220,10 +369,19 @@ func (m *managementConsole) CmdTokensListInstances(args unixsock.Args) *unixsock
 // CmdTokensListServices lists all permitted services
 func (m *managementConsole) CmdTokensListServices(args unixsock.Args) *unixsock.Response {
 
+	// m.logserver.tokens is also written by the token-store watch goroutine,
+	// so it must only be read under m.logserver.mu
+	m.logserver.mu.RLock()
+	keys := make([]string, 0, len(m.logserver.tokens))
+	for key := range m.logserver.tokens {
+		keys = append(keys, key)
+	}
+	m.logserver.mu.RUnlock()
+
 	// Prepare statistics
 	serviceNames := []string{}
 	services := map[string][2]int{}
-	for key := range m.logserver.tokens {
+	for _, key := range keys {
 		parts := strings.Split(key, "/")
 		if len(parts) != 2 {
 			continue
@@ -266,17 +424,174 @@ func (m *managementConsole) CmdLogsList(args unixsock.Args) *unixsock.Response {
 	return &unixsock.Response{}
 }
 
-// CmdRemoteAdd adds a remote backend
+// logsTailFollowWindow bounds how long CmdLogsTail keeps waiting for
+// additional matching entries when follow is requested. ManagementConsole
+// commands reply with a single synchronous *unixsock.Response per call, not a
+// stream, so follow cannot keep a command open until the client disconnects;
+// instead it blocks for this window, folds whatever new entries arrive into
+// the same response, and returns. Callers that want to keep watching issue
+// logs.tail again.
+const logsTailFollowWindow = 5 * time.Second
+
+// CmdLogsTail displays the most recent log entries of a service/instance,
+// optionally filtered by level/contains. If follow is true, it additionally
+// blocks for up to logsTailFollowWindow collecting new matching entries
+// before replying with all of them in a single response.
+func (m *managementConsole) CmdLogsTail(args unixsock.Args) *unixsock.Response {
+
+	// Validate arguments
+	required := []arg{
+		arg{"service", reflect.String},
+		arg{"instance", reflect.String},
+		arg{"n", reflect.Float64},
+	}
+	if !validArguments(args, required) {
+		return respMissingArgs
+	}
+
+	service := args["service"].(string)
+	instance := args["instance"].(string)
+	n := int(args["n"].(float64))
+
+	level, _ := args["level"].(string)
+	contains, _ := args["contains"].(string)
+	follow, _ := args["follow"].(bool)
+
+	key := getCleanKey(service, instance)
+	buffer := m.logserver.bufferFor(key)
+	entries := buffer.tail(n, level, contains)
+
+	if follow {
+		ch := make(chan LogEntry, 64)
+		buffer.subscribe(ch)
+		defer buffer.unsubscribe(ch)
+
+		deadline := time.After(logsTailFollowWindow)
+	followLoop:
+		for {
+			select {
+			case entry := <-ch:
+				if level != "" && !strings.EqualFold(entry.Level, level) {
+					continue
+				}
+				if contains != "" && !strings.Contains(entry.Raw, contains) {
+					continue
+				}
+				entries = append(entries, entry)
+			case <-deadline:
+				break followLoop
+			}
+		}
+	}
+
+	// Prepare table
+	table := lentele.New("Timestamp", "Level", "Size", "Entry")
+	table.AddTitle(fmt.Sprintf("%s/%s: recent log entries", service, instance))
+	for _, e := range entries {
+		table.AddRow("").Insert(e.Timestamp.Format("2006-01-02 15:04:05"), e.Level, e.Size, e.Raw)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	table.Render(buf, false, true, false, lentele.LoadTemplate("classic"))
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: buf.String(),
+	}
+}
+
+// CmdRemoteAdd registers a new backend (syslog or forward) that every
+// received log entry is additionally fanned out to
 func (m *managementConsole) CmdRemoteAdd(args unixsock.Args) *unixsock.Response {
-	return &unixsock.Response{}
+
+	// Validate arguments
+	required := []arg{
+		arg{"name", reflect.String},
+		arg{"type", reflect.String},
+		arg{"address", reflect.String},
+	}
+	if !validArguments(args, required) {
+		return respMissingArgs
+	}
+
+	cfg := BackendConfig{
+		Name:    args["name"].(string),
+		Type:    BackendType(strings.ToLower(args["type"].(string))),
+		Address: args["address"].(string),
+	}
+	if network, ok := args["network"].(string); ok {
+		cfg.Network = strings.ToLower(network)
+	}
+	if certFile, ok := args["tls_cert_file"].(string); ok {
+		cfg.TLSCertFile = certFile
+	}
+	if keyFile, ok := args["tls_key_file"].(string); ok {
+		cfg.TLSKeyFile = keyFile
+	}
+	if insecure, ok := args["insecure_skip_verify"].(bool); ok {
+		cfg.InsecureSkipVerify = insecure
+	}
+	if token, ok := args["token"].(string); ok {
+		cfg.Token = token
+	}
+	if service, ok := args["service"].(string); ok {
+		cfg.Service = service
+	}
+	if instance, ok := args["instance"].(string); ok {
+		cfg.Instance = instance
+	}
+
+	if err := m.logserver.backends.Add(cfg); err != nil {
+		return &unixsock.Response{
+			Status: "failure",
+			Error:  fmt.Errorf("Could not add backend: %s", err.Error()).Error(),
+		}
+	}
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: fmt.Sprintf("Backend %q added", cfg.Name),
+	}
 }
 
-// CmdRemoteRemove removes a remote backend
+// CmdRemoteRemove unregisters and closes a backend
 func (m *managementConsole) CmdRemoteRemove(args unixsock.Args) *unixsock.Response {
-	return &unixsock.Response{}
+
+	required := []arg{
+		arg{"name", reflect.String},
+	}
+	if !validArguments(args, required) {
+		return respMissingArgs
+	}
+
+	name := args["name"].(string)
+	if err := m.logserver.backends.Remove(name); err != nil {
+		return &unixsock.Response{
+			Status: "failure",
+			Error:  fmt.Errorf("Could not remove backend: %s", err.Error()).Error(),
+		}
+	}
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: fmt.Sprintf("Backend %q removed", name),
+	}
 }
 
-// CmdRemoteList lists all active remote backends
+// CmdRemoteList lists all registered backends
 func (m *managementConsole) CmdRemoteList(args unixsock.Args) *unixsock.Response {
-	return &unixsock.Response{}
+
+	table := lentele.New("Name", "Type", "Network", "Address")
+	table.AddTitle("Registered backends")
+	for _, cfg := range m.logserver.backends.List() {
+		table.AddRow("").Insert(cfg.Name, string(cfg.Type), cfg.Network, cfg.Address)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	table.Render(buf, false, true, false, lentele.LoadTemplate("classic"))
+
+	return &unixsock.Response{
+		Status:  "success",
+		Payload: buf.String(),
+	}
 }
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyProtocolHeaderTimeout bounds how long Accept waits for a PROXY
+// protocol header before giving up on that connection. Without a deadline, a
+// client that opens a connection and withholds (or trickles) its header
+// would block Accept indefinitely, starving the listener of every other
+// connection since Accept is only ever called serially by one goroutine.
+const proxyProtocolHeaderTimeout = 5 * time.Second
+
+// proxyProtocolV2Signature is the 12-byte magic that opens every PROXY
+// protocol v2 header
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// proxyProtocolListener wraps a net.Listener so every connection it accepts
+// has its PROXY protocol header (v1 or v2) parsed and stripped before being
+// handed to the caller, with RemoteAddr() reporting the real client address
+// carried by that header instead of the load balancer's.
+type proxyProtocolListener struct {
+	net.Listener
+	version ProxyProtocolVersion
+}
+
+// newProxyProtocolListener wraps lis so Accept() parses a PROXY protocol
+// header of the given version off every new connection
+func newProxyProtocolListener(lis net.Listener, version ProxyProtocolVersion) net.Listener {
+	return &proxyProtocolListener{Listener: lis, version: version}
+}
+
+// proxyHeaderError reports a single connection dropped for a missing,
+// malformed or late PROXY protocol header. It is Temporary, so grpc's Serve
+// keeps accepting other connections instead of returning.
+type proxyHeaderError struct{ err error }
+
+func (e *proxyHeaderError) Error() string   { return e.err.Error() }
+func (e *proxyHeaderError) Temporary() bool { return true }
+
+// Accept blocks until a connection arrives, then parses and strips its
+// PROXY protocol header before returning it. A connection whose header
+// cannot be read is closed and reported as a temporary proxyHeaderError.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout)); err != nil {
+		conn.Close()
+		return nil, &proxyHeaderError{fmt.Errorf("proxyProtocolListener: could not set read deadline: %s", err.Error())}
+	}
+
+	reader := bufio.NewReader(conn)
+	remoteAddr, err := parseProxyHeader(reader, l.version)
+	if err != nil {
+		conn.Close()
+		return nil, &proxyHeaderError{fmt.Errorf("proxyProtocolListener: could not parse PROXY header from %s: %s", conn.RemoteAddr(), err.Error())}
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, &proxyHeaderError{fmt.Errorf("proxyProtocolListener: could not clear read deadline: %s", err.Error())}
+	}
+
+	return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: remoteAddr}, nil
+}
+
+// proxyProtocolConn is a net.Conn whose Read is served from a buffered
+// reader (so bytes consumed while parsing the PROXY header aren't lost) and
+// whose RemoteAddr reports the client address carried by that header
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+// Read reads through the buffered reader left over from header parsing
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr reports the real client address, not the proxy's
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// parseProxyHeader reads and strips a PROXY protocol header of the given
+// version from r, returning the real client address it carries
+func parseProxyHeader(r *bufio.Reader, version ProxyProtocolVersion) (net.Addr, error) {
+	switch version {
+	case ProxyProtocolV1:
+		return parseProxyHeaderV1(r)
+	case ProxyProtocolV2:
+		return parseProxyHeaderV2(r)
+	default:
+		return nil, fmt.Errorf("parseProxyHeader: unsupported PROXY protocol version %q", version)
+	}
+}
+
+// parseProxyHeaderV1 parses the text encoding:
+// "PROXY TCP4 <src ip> <dst ip> <src port> <dst port>\r\n" (or TCP6, or
+// "PROXY UNKNOWN\r\n" for connections the proxy can't attribute)
+func parseProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV1: could not read header line: %s", err.Error())
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("parseProxyHeaderV1: malformed header %q", line)
+	}
+	if fields[1] == "UNKNOWN" {
+		return &net.TCPAddr{}, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("parseProxyHeaderV1: malformed header %q", line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("parseProxyHeaderV1: invalid source address %q", fields[2])
+	}
+	port, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV1: invalid source port %q", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// parseProxyHeaderV2 parses the binary encoding: a 12-byte signature, one
+// version/command byte, one family/protocol byte, a big-endian address
+// length, then the address block itself
+func parseProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	sig := make([]byte, len(proxyProtocolV2Signature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV2: could not read signature: %s", err.Error())
+	}
+	if !bytes.Equal(sig, proxyProtocolV2Signature) {
+		return nil, fmt.Errorf("parseProxyHeaderV2: bad signature")
+	}
+
+	verCmdFam := make([]byte, 2)
+	if _, err := io.ReadFull(r, verCmdFam); err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV2: could not read version/command/family: %s", err.Error())
+	}
+	if version := verCmdFam[0] >> 4; version != 2 {
+		return nil, fmt.Errorf("parseProxyHeaderV2: unsupported version %d", version)
+	}
+	command := verCmdFam[0] & 0x0F
+	family := verCmdFam[1] >> 4
+
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV2: could not read address length: %s", err.Error())
+	}
+
+	addrBytes := make([]byte, length)
+	if _, err := io.ReadFull(r, addrBytes); err != nil {
+		return nil, fmt.Errorf("parseProxyHeaderV2: could not read address block: %s", err.Error())
+	}
+
+	// A LOCAL command (e.g. a load balancer's own health check) carries no
+	// real client address; fall back to an unspecified one rather than
+	// failing the connection
+	if command == 0x00 {
+		return &net.TCPAddr{}, nil
+	}
+
+	switch family {
+	case 0x01: // AF_INET
+		if len(addrBytes) < 12 {
+			return nil, fmt.Errorf("parseProxyHeaderV2: short IPv4 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrBytes[0:4]),
+			Port: int(binary.BigEndian.Uint16(addrBytes[8:10])),
+		}, nil
+	case 0x02: // AF_INET6
+		if len(addrBytes) < 36 {
+			return nil, fmt.Errorf("parseProxyHeaderV2: short IPv6 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrBytes[0:16]),
+			Port: int(binary.BigEndian.Uint16(addrBytes[32:34])),
+		}, nil
+	default:
+		return nil, fmt.Errorf("parseProxyHeaderV2: unsupported address family %d", family)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogEntry is a single line retained in a service/instance's ring buffer
+type LogEntry struct {
+	Timestamp time.Time
+	Level     string // best-effort, parsed from the raw entry; empty if unrecognized
+	Size      int
+	Raw       string
+}
+
+// ringBuffer is a fixed-size, overwrite-oldest buffer of the most recent
+// LogEntry values received for a single service/instance, following the
+// pattern used by micro's debug/log/memory backend.
+type ringBuffer struct {
+	mu          sync.Mutex
+	entries     []LogEntry
+	next        int
+	full        bool
+	subscribers []chan LogEntry
+}
+
+// newRingBuffer creates a ringBuffer holding up to size entries; size <= 0
+// falls back to 1024, matching Config.RecentBufferSize's default
+func newRingBuffer(size int) *ringBuffer {
+	if size <= 0 {
+		size = 1024
+	}
+	return &ringBuffer{entries: make([]LogEntry, size)}
+}
+
+// add appends entry, overwriting the oldest retained entry once the buffer
+// is full, and fans it out to every subscribed channel
+func (b *ringBuffer) add(entry LogEntry) {
+	b.mu.Lock()
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	subs := append([]chan LogEntry{}, b.subscribers...)
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub <- entry:
+		default:
+		}
+	}
+}
+
+// tail returns up to n of the most recent entries (oldest first) matching
+// level (case-insensitive, exact) and contains (substring); either filter is
+// skipped when empty, and n <= 0 returns every retained entry
+func (b *ringBuffer) tail(n int, level, contains string) []LogEntry {
+	b.mu.Lock()
+	ordered := make([]LogEntry, 0, len(b.entries))
+	if b.full {
+		for i := 0; i < len(b.entries); i++ {
+			ordered = append(ordered, b.entries[(b.next+i)%len(b.entries)])
+		}
+	} else {
+		ordered = append(ordered, b.entries[:b.next]...)
+	}
+	b.mu.Unlock()
+
+	filtered := make([]LogEntry, 0, len(ordered))
+	for _, e := range ordered {
+		if level != "" && !strings.EqualFold(e.Level, level) {
+			continue
+		}
+		if contains != "" && !strings.Contains(e.Raw, contains) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+
+	if n > 0 && len(filtered) > n {
+		filtered = filtered[len(filtered)-n:]
+	}
+
+	return filtered
+}
+
+// subscribe registers ch to receive every entry added after this call
+func (b *ringBuffer) subscribe(ch chan LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, ch)
+}
+
+// unsubscribe removes ch from the subscriber list
+func (b *ringBuffer) unsubscribe(ch chan LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subscribers {
+		if sub == ch {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// parseLevel makes a best-effort guess at a raw log line's severity level by
+// looking for a recognized level token; returns "" if none is found
+func parseLevel(raw string) string {
+	upper := strings.ToUpper(raw)
+	for _, level := range []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"} {
+		if strings.Contains(upper, level) {
+			return level
+		}
+	}
+	return ""
+}
@@ -1,19 +1,43 @@
 package server
 
 import (
-	"bufio"
 	rand "crypto/rand"
 	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"strings"
+	"time"
 )
 
-// AddToken creates a new token for the service/instance if it does not yet exist
-func (l *logServer) AddToken(service, instance string) (string, error) {
-	l.Lock()
-	defer l.Unlock()
+// tokenReapInterval is how often reapExpiredTokens sweeps the in-memory
+// cache for fully expired tokens and lapsed grace-period leftovers
+const tokenReapInterval = time.Minute
+
+// randomToken generates a random, SHA-256-derived token
+func randomToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("randomToken: could not generate a random token: %s", err.Error())
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(tokenBytes)), nil
+}
+
+// AddToken creates a new token for the service/instance if it does not yet
+// exist. The token never expires; use AddTokenWithTTL for a token with a
+// lifetime.
+func (l *LogServer) AddToken(service, instance string) (string, error) {
+	return l.addToken(service, instance, 0)
+}
+
+// AddTokenWithTTL creates a new token for the service/instance that expires
+// after ttl has elapsed; a zero ttl never expires, matching AddToken.
+func (l *LogServer) AddTokenWithTTL(service, instance string, ttl time.Duration) (string, error) {
+	return l.addToken(service, instance, ttl)
+}
+
+// addToken is the shared implementation behind AddToken/AddTokenWithTTL
+func (l *LogServer) addToken(service, instance string, ttl time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	// Clean the key
 	key := getCleanKey(service, instance)
@@ -24,19 +48,27 @@ func (l *logServer) AddToken(service, instance string) (string, error) {
 	}
 
 	// Create a random token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", fmt.Errorf("AddToken: could not generate a random token: %s", err.Error())
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("AddToken: %s", err.Error())
 	}
-	token := fmt.Sprintf("%x", sha256.Sum256(tokenBytes))
 
-	// Write the token database to file
-	if err := l.writeTokenToFile(key, token); err != nil {
-		return "", fmt.Errorf("AddToken: could not write token to file: %s", err.Error())
+	record := &TokenRecord{Token: token, IssuedAt: time.Now()}
+	if ttl > 0 {
+		record.ExpiresAt = record.IssuedAt.Add(ttl)
+	}
+
+	// Persist the token to the store
+	enc, err := encodeTokenRecord(*record)
+	if err != nil {
+		return "", fmt.Errorf("AddToken: could not encode token record: %s", err.Error())
+	}
+	if err := l.store.Put(key, enc); err != nil {
+		return "", fmt.Errorf("AddToken: could not write token to store: %s", err.Error())
 	}
 
 	// Assign token to the key
-	l.tokens[key] = token
+	l.tokens[key] = record
 	l.stats[key] = &Statistic{
 		Service:  service,
 		Instance: instance,
@@ -45,23 +77,96 @@ func (l *logServer) AddToken(service, instance string) (string, error) {
 	return token, nil
 }
 
-// GetTokens returns LogServer's tokens
-func (l *logServer) GetTokens() map[string]string {
-	l.Lock()
-	l.Unlock()
+// RotateToken issues a new token for the service/instance while keeping the
+// old one valid for gracePeriod, so in-flight clients have time to pick up
+// the new token before the old one stops working.
+func (l *LogServer) RotateToken(service, instance string, gracePeriod time.Duration) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := getCleanKey(service, instance)
+
+	existing, ok := l.tokens[key]
+	if !ok {
+		return "", fmt.Errorf("RotateToken: no such service/instance")
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("RotateToken: %s", err.Error())
+	}
+
+	now := time.Now()
+	record := &TokenRecord{
+		Token:         token,
+		IssuedAt:      now,
+		ExpiresAt:     existing.ExpiresAt,
+		PrevToken:     existing.Token,
+		PrevExpiresAt: now.Add(gracePeriod),
+	}
+
+	enc, err := encodeTokenRecord(*record)
+	if err != nil {
+		return "", fmt.Errorf("RotateToken: could not encode token record: %s", err.Error())
+	}
+	if err := l.store.Put(key, enc); err != nil {
+		return "", fmt.Errorf("RotateToken: could not write token to store: %s", err.Error())
+	}
+
+	l.tokens[key] = record
+	l.slog.Info("rotated token", "service", service, "instance", instance, "grace_period", gracePeriod.String())
+
+	return token, nil
+}
+
+// ExpireToken immediately invalidates the current and any grace-period
+// token of a service/instance, without removing its statistics; reapExpiredTokens
+// will clear the entry from both memory and the store on its next sweep.
+func (l *LogServer) ExpireToken(service, instance string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := getCleanKey(service, instance)
+
+	record, ok := l.tokens[key]
+	if !ok {
+		return fmt.Errorf("ExpireToken: no such service/instance")
+	}
+
+	now := time.Now()
+	record.ExpiresAt = now
+	record.PrevToken = ""
+	record.PrevExpiresAt = time.Time{}
+
+	enc, err := encodeTokenRecord(*record)
+	if err != nil {
+		return fmt.Errorf("ExpireToken: could not encode token record: %s", err.Error())
+	}
+	if err := l.store.Put(key, enc); err != nil {
+		return fmt.Errorf("ExpireToken: could not write token to store: %s", err.Error())
+	}
+
+	l.slog.Warn("expired token", "service", service, "instance", instance)
+	return nil
+}
+
+// GetTokens returns LogServer's current tokens
+func (l *LogServer) GetTokens() map[string]string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
 	copyTokens := map[string]string{}
-	for key, token := range l.tokens {
-		copyTokens[key] = token
+	for key, record := range l.tokens {
+		copyTokens[key] = record.Token
 	}
 
 	return copyTokens
 }
 
 // RemoveTokens removes all the authentication tokens of a service
-func (l *logServer) RemoveTokens(service string) error {
-	l.Lock()
-	defer l.Unlock()
+func (l *LogServer) RemoveTokens(service string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
 	// Identify all the keys belonging to a service
 	keys := []string{}
@@ -74,7 +179,7 @@ func (l *logServer) RemoveTokens(service string) error {
 	// Remove keys one by one
 	for _, key := range keys {
 		parts := strings.Split(key, "/")
-		if err := l.RemoveToken(parts[0], parts[1], false); err != nil {
+		if err := l.removeToken(parts[0], parts[1]); err != nil {
 			return fmt.Errorf("RemoveTokens: could not remove token for key '%s': %s", key, err.Error())
 		}
 	}
@@ -83,135 +188,105 @@ func (l *logServer) RemoveTokens(service string) error {
 }
 
 // RemoveToken removes an authentication token
-func (l *logServer) RemoveToken(service, instance string, lock bool) error {
-	if lock {
-		l.Lock()
-		defer l.Unlock()
-	}
+func (l *LogServer) RemoveToken(service, instance string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.removeToken(service, instance)
+}
+
+// removeToken removes an authentication token; callers must hold l.mu
+func (l *LogServer) removeToken(service, instance string) error {
 
 	// Clean the key
 	key := getCleanKey(service, instance)
 
 	// Check that the key exists
 	if _, ok := l.tokens[key]; !ok {
-		return fmt.Errorf("RemoveToken: no such service/instance")
+		return fmt.Errorf("removeToken: no such service/instance")
 	}
 
-	// Remove the token from file
-	if err := l.removeTokenFromFile(key, false); err != nil {
-		return fmt.Errorf("RemoveToken: could not remove token for %s: %s", key, err.Error())
+	// Remove the token from the store
+	if err := l.store.Delete(key); err != nil {
+		return fmt.Errorf("removeToken: could not remove token for %s: %s", key, err.Error())
 	}
 
 	// Remove from memory
 	delete(l.tokens, key)
+	delete(l.stats, key)
+
+	l.logsMu.Lock()
+	delete(l.logs, key)
+	l.logsMu.Unlock()
 
 	return nil
 }
 
-// writeTokenToFile writes a tokens to file
-func (l *logServer) writeTokenToFile(key, token string) error {
-
-	// Make sure file is writeable
-	if err := fileExists(l.tokenPath); err != nil {
-		return fmt.Errorf("writeTokenToFile: could not create tokens.db: %s", err.Error())
-	}
-
-	// Write to file
-	f, err := os.OpenFile(l.tokenPath, os.O_WRONLY|os.O_APPEND, 0600)
-	if err == nil {
-		if _, err = f.WriteString(fmt.Sprintf("%s\t%s\n", key, token)); err != nil {
-			return fmt.Errorf("writeTokenToFile: could not write token to file: %s", err.Error())
+// reapExpiredTokens periodically removes fully expired tokens from memory
+// and the backing store, and clears lapsed grace-period fields once the
+// previous token's own expiry has passed
+func (l *LogServer) reapExpiredTokens(quit <-chan bool) {
+	ticker := time.NewTicker(tokenReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.reapOnce()
+		case <-quit:
+			return
 		}
-	} else {
-		return fmt.Errorf("writeTokenToFile: could not open file: %s", err.Error())
 	}
-
-	return f.Close()
-
 }
 
-// removeTokenFromFile removes a single token from the tokens.db
-func (l *logServer) removeTokenFromFile(key string, lock bool) error {
-	if lock {
-		l.Lock()
-		defer l.Unlock()
-	}
+// reapOnce runs a single reap sweep
+func (l *LogServer) reapOnce() {
+	now := time.Now()
 
-	// Make sure file exists
-	if err := fileExists(l.tokenPath); err != nil {
-		return fmt.Errorf("removeTokenFromFile: could not create tokens database: %s", err.Error())
-	}
+	expired := []string{}
+	lapsed := map[string]*TokenRecord{}
 
-	// Open file for reading
-	f, err := os.OpenFile(l.tokenPath, os.O_RDWR, 600)
-	if err != nil {
-		return fmt.Errorf("removeTokenFromFile: could not open token database for reading: %s", err.Error())
+	l.mu.Lock()
+	for key, record := range l.tokens {
+		if record.Expired(now) && !record.prevValid(now) {
+			// Both the current token and any grace period have lapsed: the
+			// record has nothing left to authorize, so it can be removed.
+			expired = append(expired, key)
+			continue
+		}
+		if record.PrevToken != "" && !record.prevValid(now) {
+			// Only the grace-period token lapsed; the still-valid current
+			// token keeps the record alive, so just drop the stale
+			// previous-token fields.
+			record.PrevToken = ""
+			record.PrevExpiresAt = time.Time{}
+			lapsed[key] = record
+		}
 	}
+	l.mu.Unlock()
 
-	// Read all except for the key
-	fileScanner := bufio.NewScanner(f)
-	tokens := []string{}
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-
-		parts := strings.Split(line, "\t")
-		if len(parts) != 2 {
+	for key, record := range lapsed {
+		enc, err := encodeTokenRecord(*record)
+		if err != nil {
+			l.slog.Warn("could not encode token record while clearing lapsed grace period", "key", key, "error", err.Error())
 			continue
 		}
-		keyParts := strings.Split(parts[0], "/")
-		if len(keyParts) != 2 {
+		if err := l.store.Put(key, enc); err != nil {
+			l.slog.Warn("could not persist token record while clearing lapsed grace period", "key", key, "error", err.Error())
 			continue
 		}
-
-		if parts[0] != key {
-			tokens = append(tokens, line)
-		}
+		l.slog.Info("cleared lapsed grace-period token", "key", key)
 	}
 
-	if err := f.Close(); err != nil {
-		return err
-	}
-
-	tokens = append(tokens, "\n")
-
-	// Revwrite tokens.db
-	if err := ioutil.WriteFile(l.tokenPath, []byte(strings.Join(tokens, "\n")), 0600); err != nil {
-		return fmt.Errorf("removeTokenFromFile: could not rewrite token database: %s", err.Error())
-	}
-
-	return nil
-}
-
-// loadTokensFromDisk loads all the tokens from disk to memory
-func (l *logServer) loadTokensFromDisk() error {
-	l.Lock()
-	defer l.Unlock()
-
-	// Make sure file exists
-	if err := fileExists(l.tokenPath); err != nil {
-		return fmt.Errorf("loadTokensFromDisk: could not create tokens.db: %s", err.Error())
-	}
-
-	// Open file for reading
-	f, err := os.OpenFile(l.tokenPath, os.O_RDONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("loadTokensFromDisk: could not open token file for reading: %s", err.Error())
-	}
-
-	// Read line by line and add to the in-memory db
-	fileScanner := bufio.NewScanner(f)
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		parts := strings.Split(line, "\t")
+	for _, key := range expired {
+		parts := strings.Split(key, "/")
 		if len(parts) != 2 {
 			continue
 		}
-		keyParts := strings.Split(parts[0], "/")
-		if len(keyParts) != 2 {
+		if err := l.RemoveToken(parts[0], parts[1]); err != nil {
+			l.slog.Warn("could not reap expired token", "key", key, "error", err.Error())
 			continue
 		}
-		l.tokens[parts[0]] = parts[1]
+		l.slog.Info("reaped expired token", "key", key)
 	}
-
-	return f.Close()
 }
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	context "golang.org/x/net/context"
+)
+
+// TokenEventType enumerates the kinds of changes a TokenStore can report
+// over its Watch channel.
+type TokenEventType int
+
+const (
+	// TokenEventPut is emitted whenever a token is created or updated
+	TokenEventPut TokenEventType = iota
+	// TokenEventDelete is emitted whenever a token is removed
+	TokenEventDelete
+)
+
+// TokenEvent describes a single change observed on a TokenStore, whether
+// caused locally or by another LogServer replica sharing the same backend.
+// Token carries the encoded TokenRecord (see encodeTokenRecord); it is empty
+// for TokenEventDelete.
+type TokenEvent struct {
+	Type  TokenEventType
+	Key   string
+	Token string
+}
+
+// TokenStore persists service/instance authorization tokens and notifies
+// watchers of changes made anywhere in the cluster. Implementations must be
+// safe for concurrent use. Stores are agnostic of the value they carry: the
+// caller (LogServer) encodes a TokenRecord into the opaque token string via
+// encodeTokenRecord/decodeTokenRecord, so TTL and rotation metadata ride
+// along without changing this interface.
+type TokenStore interface {
+
+	// Get returns the token for a key, ok is false if no such key exists
+	Get(key string) (token string, ok bool, err error)
+
+	// Put creates or overwrites the token for a key
+	Put(key, token string) error
+
+	// Delete removes the token for a key
+	Delete(key string) error
+
+	// List returns all known key/token pairs
+	List() (map[string]string, error)
+
+	// Watch streams Put/Delete events until ctx is cancelled. The returned
+	// channel is closed once watching stops.
+	Watch(ctx context.Context) <-chan TokenEvent
+
+	// Close releases any connection or resource held by the store. It does
+	// not cancel in-flight Watch calls; cancel their context first.
+	Close() error
+}
+
+// TokenRecord describes a token's full lifecycle: when it was issued, when
+// it expires, and - during the grace period of a rotation - the previous
+// token that remains valid alongside the current one.
+type TokenRecord struct {
+	Token    string
+	IssuedAt time.Time
+
+	// ExpiresAt is the zero time if the token never expires
+	ExpiresAt time.Time
+
+	// PrevToken is the token Token replaced during the last rotation; it
+	// stays valid until PrevExpiresAt so in-flight clients have time to pick
+	// up the new token. Empty if no rotation is in progress.
+	PrevToken     string
+	PrevExpiresAt time.Time
+}
+
+// Expired reports whether the record's current token has passed its expiry
+func (r TokenRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// prevValid reports whether the pre-rotation token is still usable
+func (r TokenRecord) prevValid(now time.Time) bool {
+	return r.PrevToken != "" && (r.PrevExpiresAt.IsZero() || now.Before(r.PrevExpiresAt))
+}
+
+// encodeTokenRecord serializes a TokenRecord into the opaque string carried
+// by TokenStore.Put/Get/TokenEvent
+func encodeTokenRecord(record TokenRecord) (string, error) {
+	enc, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("encodeTokenRecord: could not marshal token record: %s", err.Error())
+	}
+	return string(enc), nil
+}
+
+// decodeTokenRecord parses the opaque string carried by TokenStore into a
+// TokenRecord. For backward compatibility with tokens written before TTL and
+// rotation support existed, a value that isn't a JSON object is treated as a
+// bare token with no lifecycle metadata.
+func decodeTokenRecord(value string) (TokenRecord, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return TokenRecord{Token: value}, nil
+	}
+
+	var record TokenRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return TokenRecord{}, fmt.Errorf("decodeTokenRecord: could not unmarshal token record: %s", err.Error())
+	}
+	return record, nil
+}
+
+// getCleanKey normalizes a service/instance pair into the key format used by
+// every TokenStore implementation and the in-memory cache
+func getCleanKey(service, instance string) string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(service), strings.ToLower(instance))
+}
+
+// splitKey reverses getCleanKey, returning ok=false if key isn't a valid service/instance pair
+func splitKey(key string) (service, instance string, ok bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// fileExists makes sure that path exists, creating an empty file if needed
+func fileExists(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		f, errCreate := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+		if errCreate != nil {
+			return fmt.Errorf("fileExists: could not create %s: %s", path, errCreate.Error())
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// fileTokenStoreHeader marks a tokens.db file as using the v2, TTL/rotation
+// aware line format (key\ttoken\tissued_at\texpires_at\tprev_token\tprev_expires_at).
+// Files without this header are assumed to be the original v1 format
+// (key\ttoken) and are read as tokens with no lifecycle metadata; they are
+// upgraded to v2 the next time they are rewritten.
+const fileTokenStoreHeader = "#journal-tokens-v2"
+
+// FileTokenStore is the default TokenStore, backed by a tab-separated
+// tokens.db file on disk. It has no external dependencies, so it remains the
+// zero-value Config's choice, but since it has no way of observing changes
+// made by other processes, Watch only reports changes made through this same
+// instance.
+type FileTokenStore struct {
+	path string
+
+	mu          sync.Mutex
+	subscribers []chan TokenEvent
+}
+
+// NewFileTokenStore creates a FileTokenStore backed by the tokens.db file at path
+func NewFileTokenStore(path string) (*FileTokenStore, error) {
+	if err := fileExists(path); err != nil {
+		return nil, fmt.Errorf("NewFileTokenStore: could not create tokens.db: %s", err.Error())
+	}
+	return &FileTokenStore{path: path}, nil
+}
+
+// readAll loads every record from the tokens.db file, transparently
+// upgrading legacy (headerless, key\ttoken) lines into TokenRecords with no
+// TTL/rotation metadata; callers must hold s.mu
+func (s *FileTokenStore) readAll() (map[string]TokenRecord, error) {
+	if err := fileExists(s.path); err != nil {
+		return nil, fmt.Errorf("readAll: could not create tokens.db: %s", err.Error())
+	}
+
+	f, err := os.OpenFile(s.path, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("readAll: could not open token file for reading: %s", err.Error())
+	}
+	defer f.Close()
+
+	records := map[string]TokenRecord{}
+	firstLine := true
+	fileScanner := bufio.NewScanner(f)
+	for fileScanner.Scan() {
+		line := fileScanner.Text()
+		if firstLine {
+			firstLine = false
+			if line == fileTokenStoreHeader {
+				continue
+			}
+		}
+
+		parts := strings.Split(line, "\t")
+		switch len(parts) {
+		case 2:
+			records[parts[0]] = TokenRecord{Token: parts[1]}
+		case 6:
+			records[parts[0]] = TokenRecord{
+				Token:         parts[1],
+				IssuedAt:      parseTokenTime(parts[2]),
+				ExpiresAt:     parseTokenTime(parts[3]),
+				PrevToken:     parts[4],
+				PrevExpiresAt: parseTokenTime(parts[5]),
+			}
+		default:
+			continue
+		}
+	}
+
+	return records, nil
+}
+
+// writeAll rewrites the tokens.db file in the v2 format; callers must hold s.mu
+func (s *FileTokenStore) writeAll(records map[string]TokenRecord) error {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{fileTokenStoreHeader}
+	for _, key := range keys {
+		r := records[key]
+		lines = append(lines, strings.Join([]string{
+			key,
+			r.Token,
+			formatTokenTime(r.IssuedAt),
+			formatTokenTime(r.ExpiresAt),
+			r.PrevToken,
+			formatTokenTime(r.PrevExpiresAt),
+		}, "\t"))
+	}
+
+	content := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(s.path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("writeAll: could not rewrite token database: %s", err.Error())
+	}
+	return nil
+}
+
+// formatTokenTime renders t for the tokens.db file, using an empty field for the zero time
+func formatTokenTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseTokenTime parses a tokens.db time field, treating an empty field (or
+// a value it cannot parse) as the zero time
+func parseTokenTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Get returns the token for a key
+func (s *FileTokenStore) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.readAll()
+	if err != nil {
+		return "", false, err
+	}
+
+	record, ok := records[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	enc, err := encodeTokenRecord(record)
+	return enc, true, err
+}
+
+// Put creates or overwrites the token for a key
+func (s *FileTokenStore) Put(key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := decodeTokenRecord(token)
+	if err != nil {
+		return fmt.Errorf("Put: could not decode token record: %s", err.Error())
+	}
+
+	records, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	records[key] = record
+
+	if err := s.writeAll(records); err != nil {
+		return err
+	}
+
+	s.notify(TokenEvent{Type: TokenEventPut, Key: key, Token: token})
+	return nil
+}
+
+// Delete removes the token for a key
+func (s *FileTokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	delete(records, key)
+
+	if err := s.writeAll(records); err != nil {
+		return err
+	}
+
+	s.notify(TokenEvent{Type: TokenEventDelete, Key: key})
+	return nil
+}
+
+// List returns all known key/token pairs
+func (s *FileTokenStore) List() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := map[string]string{}
+	for key, record := range records {
+		enc, err := encodeTokenRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		tokens[key] = enc
+	}
+
+	return tokens, nil
+}
+
+// Watch streams Put/Delete events made through this FileTokenStore instance.
+// Since the backing file is not itself watched, changes made by another
+// process sharing the same path are not observed; use EtcdTokenStore or
+// ConsulTokenStore to share a token database across LogServer replicas.
+func (s *FileTokenStore) Watch(ctx context.Context) <-chan TokenEvent {
+	ch := make(chan TokenEvent, 16)
+
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, ch)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, sub := range s.subscribers {
+			if sub == ch {
+				s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// Close is a no-op: a FileTokenStore holds no connection, only the path
+// it reads and writes on every call
+func (s *FileTokenStore) Close() error {
+	return nil
+}
+
+// notify fans out an event to every subscriber; callers must hold s.mu
+func (s *FileTokenStore) notify(event TokenEvent) {
+	for _, sub := range s.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	context "golang.org/x/net/context"
+)
+
+// ConsulTokenStore is a TokenStore backed by Consul's KV store, letting
+// multiple LogServer replicas behind a load balancer share a single token
+// database and observe tokens added or revoked on another node.
+type ConsulTokenStore struct {
+	client *api.Client
+	prefix string
+}
+
+// NewConsulTokenStore creates a ConsulTokenStore storing keys under prefix
+// (e.g. "journal/tokens/") using the given Consul client address
+func NewConsulTokenStore(address, prefix string) (*ConsulTokenStore, error) {
+	client, err := api.NewClient(&api.Config{Address: address})
+	if err != nil {
+		return nil, fmt.Errorf("NewConsulTokenStore: could not connect to consul: %s", err.Error())
+	}
+
+	return &ConsulTokenStore{client: client, prefix: prefix}, nil
+}
+
+// Get returns the token for a key
+func (s *ConsulTokenStore) Get(key string) (string, bool, error) {
+	kv, _, err := s.client.KV().Get(s.prefix+key, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("Get: could not read from consul: %s", err.Error())
+	}
+	if kv == nil {
+		return "", false, nil
+	}
+
+	return string(kv.Value), true, nil
+}
+
+// Put creates or overwrites the token for a key
+func (s *ConsulTokenStore) Put(key, token string) error {
+	pair := &api.KVPair{Key: s.prefix + key, Value: []byte(token)}
+	if _, err := s.client.KV().Put(pair, nil); err != nil {
+		return fmt.Errorf("Put: could not write to consul: %s", err.Error())
+	}
+	return nil
+}
+
+// Delete removes the token for a key
+func (s *ConsulTokenStore) Delete(key string) error {
+	if _, err := s.client.KV().Delete(s.prefix+key, nil); err != nil {
+		return fmt.Errorf("Delete: could not delete from consul: %s", err.Error())
+	}
+	return nil
+}
+
+// List returns all known key/token pairs
+func (s *ConsulTokenStore) List() (map[string]string, error) {
+	pairs, _, err := s.client.KV().List(s.prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("List: could not read from consul: %s", err.Error())
+	}
+
+	tokens := map[string]string{}
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, s.prefix)
+		tokens[key] = string(pair.Value)
+	}
+
+	return tokens, nil
+}
+
+// Watch streams Put/Delete events observed anywhere in the consul cluster,
+// including changes made by other LogServer replicas, by long-polling the KV
+// prefix for changes in its ModifyIndex
+func (s *ConsulTokenStore) Watch(ctx context.Context) <-chan TokenEvent {
+	ch := make(chan TokenEvent, 16)
+
+	go func() {
+		defer close(ch)
+
+		last := map[string]*api.KVPair{}
+		waitIndex := uint64(0)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			opts := (&api.QueryOptions{
+				WaitIndex: waitIndex,
+				WaitTime:  30 * time.Second,
+			}).WithContext(ctx)
+			pairs, meta, err := s.client.KV().List(s.prefix, opts)
+			if err != nil {
+				time.Sleep(time.Second)
+				continue
+			}
+			waitIndex = meta.LastIndex
+
+			seen := map[string]bool{}
+			for _, pair := range pairs {
+				key := strings.TrimPrefix(pair.Key, s.prefix)
+				seen[key] = true
+				if prev, ok := last[key]; !ok || prev.ModifyIndex != pair.ModifyIndex {
+					ch <- TokenEvent{Type: TokenEventPut, Key: key, Token: string(pair.Value)}
+				}
+			}
+			for key := range last {
+				if !seen[key] {
+					ch <- TokenEvent{Type: TokenEventDelete, Key: key}
+				}
+			}
+
+			newLast := map[string]*api.KVPair{}
+			for _, pair := range pairs {
+				newLast[strings.TrimPrefix(pair.Key, s.prefix)] = pair
+			}
+			last = newLast
+		}
+	}()
+
+	return ch
+}
+
+// Close is a no-op: api.Client has no persistent connection to release
+func (s *ConsulTokenStore) Close() error {
+	return nil
+}
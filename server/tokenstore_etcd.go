@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	context "golang.org/x/net/context"
+)
+
+// EtcdTokenStore is a TokenStore backed by an etcd v3 cluster, letting
+// multiple LogServer replicas behind a load balancer share a single token
+// database and observe tokens added or revoked on another node.
+type EtcdTokenStore struct {
+	client *clientv3.Client
+	prefix string
+}
+
+// NewEtcdTokenStore creates an EtcdTokenStore storing keys under prefix
+// (e.g. "/journal/tokens/") on the given etcd endpoints
+func NewEtcdTokenStore(endpoints []string, prefix string, dialTimeout time.Duration) (*EtcdTokenStore, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("NewEtcdTokenStore: could not connect to etcd: %s", err.Error())
+	}
+
+	return &EtcdTokenStore{client: client, prefix: prefix}, nil
+}
+
+// Get returns the token for a key
+func (s *EtcdTokenStore) Get(key string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.prefix+key)
+	if err != nil {
+		return "", false, fmt.Errorf("Get: could not read from etcd: %s", err.Error())
+	}
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+// Put creates or overwrites the token for a key
+func (s *EtcdTokenStore) Put(key, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.client.Put(ctx, s.prefix+key, token); err != nil {
+		return fmt.Errorf("Put: could not write to etcd: %s", err.Error())
+	}
+	return nil
+}
+
+// Delete removes the token for a key
+func (s *EtcdTokenStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.client.Delete(ctx, s.prefix+key); err != nil {
+		return fmt.Errorf("Delete: could not delete from etcd: %s", err.Error())
+	}
+	return nil
+}
+
+// List returns all known key/token pairs
+func (s *EtcdTokenStore) List() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, s.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("List: could not read from etcd: %s", err.Error())
+	}
+
+	tokens := map[string]string{}
+	for _, kv := range resp.Kvs {
+		key := strings.TrimPrefix(string(kv.Key), s.prefix)
+		tokens[key] = string(kv.Value)
+	}
+
+	return tokens, nil
+}
+
+// Watch streams Put/Delete events observed anywhere in the etcd cluster,
+// including changes made by other LogServer replicas
+func (s *EtcdTokenStore) Watch(ctx context.Context) <-chan TokenEvent {
+	ch := make(chan TokenEvent, 16)
+
+	watchChan := s.client.Watch(ctx, s.prefix, clientv3.WithPrefix())
+	go func() {
+		defer close(ch)
+		for resp := range watchChan {
+			for _, ev := range resp.Events {
+				key := strings.TrimPrefix(string(ev.Kv.Key), s.prefix)
+				switch ev.Type {
+				case mvccpb.PUT:
+					ch <- TokenEvent{Type: TokenEventPut, Key: key, Token: string(ev.Kv.Value)}
+				case mvccpb.DELETE:
+					ch <- TokenEvent{Type: TokenEventDelete, Key: key}
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Close releases the underlying etcd client
+func (s *EtcdTokenStore) Close() error {
+	return s.client.Close()
+}